@@ -0,0 +1,72 @@
+// Package main runs a standalone Kademlia/Ethereum-bootnode-style
+// discovery node: it listens on UDP and answers FIND_NODE/PING requests
+// from the local routing table, letting skywire nodes discover each
+// other's (pk, address) records without a hand-maintained seed file.
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+
+	"github.com/skycoin/dmsg/cipher"
+	"github.com/skycoin/skycoin/src/util/logging"
+
+	"github.com/skycoin/skywire/pkg/snet/kad"
+)
+
+func main() {
+	var (
+		addr    = flag.String("addr", ":9090", "UDP address to listen on")
+		keyFile = flag.String("sk", "", "path to a hex-encoded secret key; a random one is generated if empty")
+	)
+	flag.Parse()
+
+	log := logging.MustGetLogger("bootnode")
+
+	pk, sk, err := loadOrGenerateKeys(*keyFile)
+	if err != nil {
+		log.Fatalf("failed to load keys: %v", err)
+	}
+	log.Infof("bootnode public key: %s", pk)
+
+	udpAddr, err := net.ResolveUDPAddr("udp", *addr)
+	if err != nil {
+		log.Fatalf("invalid -addr %q: %v", *addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		log.Fatalf("failed to listen on %v: %v", udpAddr, err)
+	}
+	defer conn.Close() // nolint: errcheck
+
+	table := kad.NewTable(pk)
+	srv := kad.NewServer(pk, sk, conn, table, log)
+
+	log.Infof("listening on %v", conn.LocalAddr())
+	if err := srv.Serve(); err != nil {
+		log.Fatalf("discovery server stopped: %v", err)
+	}
+}
+
+func loadOrGenerateKeys(path string) (cipher.PubKey, cipher.SecKey, error) {
+	if path == "" {
+		pk, sk := cipher.GenerateKeyPair()
+		return pk, sk, nil
+	}
+
+	data, err := os.ReadFile(path) // nolint: gosec
+	if err != nil {
+		return cipher.PubKey{}, cipher.SecKey{}, err
+	}
+
+	var sk cipher.SecKey
+	if err := sk.UnmarshalText(data); err != nil {
+		return cipher.PubKey{}, cipher.SecKey{}, err
+	}
+	pk, err := cipher.PubKeyFromSecKey(sk)
+	if err != nil {
+		return cipher.PubKey{}, cipher.SecKey{}, err
+	}
+	return pk, sk, nil
+}
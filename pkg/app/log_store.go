@@ -2,13 +2,21 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"go.etcd.io/bbolt"
 )
 
+// tailChanBuffer is how many lines Tail buffers on its returned channel
+// (and, for the bbolt backend, on its live subscription) ahead of a slow
+// reader. A slow reader never blocks Write: once a bbolt subscription is
+// full, further live lines for it are dropped.
+const tailChanBuffer = 64
+
 // LogStore stores logs from apps, for later consumption from the hypervisor
 type LogStore interface {
 	// Write implements io.Writer
@@ -21,6 +29,11 @@ type LogStore interface {
 	// the timestamp should exist in the store (you can get it from previous logs),
 	// otherwise the DB will be sequentially iterated until finding entries older than given timestamp
 	LogsSince(t time.Time) ([]string, error)
+
+	// Tail streams log lines since t: every matching historical entry is
+	// sent first, followed by new lines as they're written, until ctx is
+	// cancelled or the returned channel is drained and closed.
+	Tail(ctx context.Context, t time.Time) (<-chan string, error)
 }
 
 // NewLogStore returns a LogStore with path and app name of the given kind
@@ -28,14 +41,27 @@ func NewLogStore(path, appName, kind string) (LogStore, error) {
 	switch kind {
 	case "bbolt":
 		return newBoltDB(path, appName)
+	case "wal":
+		return newWALLogStore(path, appName)
 	default:
 		return nil, fmt.Errorf("no LogStore of type %s", kind)
 	}
 }
 
+// logEvent pairs a broadcast log line with the timestamp it was stored
+// under, so Tail can tell which live events are already covered by its
+// historical snapshot.
+type logEvent struct {
+	t    time.Time
+	line string
+}
+
 type boltDBappLogs struct {
 	dbpath string
 	bucket []byte
+
+	subMu sync.Mutex
+	subs  []chan logEvent
 }
 
 func newBoltDB(path, appName string) (LogStore, error) {
@@ -62,7 +88,7 @@ func newBoltDB(path, appName string) (LogStore, error) {
 		return nil, err
 	}
 
-	return &boltDBappLogs{path, b}, nil
+	return &boltDBappLogs{dbpath: path, bucket: b}, nil
 }
 
 // Write implements io.Writer
@@ -90,6 +116,11 @@ func (l *boltDBappLogs) Write(p []byte) (int, error) {
 		return 0, err
 	}
 
+	parsedTime, parseErr := bytesToTime(t)
+	if parseErr != nil {
+		parsedTime = time.Now()
+	}
+	l.broadcast(parsedTime, string(p))
 	return len(p), nil
 }
 
@@ -107,17 +138,31 @@ func (l *boltDBappLogs) Store(t time.Time, s string) error {
 	}()
 
 	parsedTime := []byte(t.Format(time.RFC3339Nano))
-	return db.Update(func(tx *bbolt.Tx) error {
+	err = db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(l.bucket)
 		return b.Put(parsedTime, []byte(s))
 	})
+	if err != nil {
+		return err
+	}
+
+	l.broadcast(t, s)
+	return nil
 }
 
 // LogSince implements LogStore
 func (l *boltDBappLogs) LogsSince(t time.Time) ([]string, error) {
+	logs, _, err := l.logsSinceWithLastKey(t)
+	return logs, err
+}
+
+// logsSinceWithLastKey is LogsSince plus the last key it read, so Tail
+// can derive a cutoff time below which a live event is already covered
+// by the historical snapshot.
+func (l *boltDBappLogs) logsSinceWithLastKey(t time.Time) ([]string, []byte, error) {
 	db, err := bbolt.Open(l.dbpath, 0600, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer func() {
 		err := db.Close()
@@ -127,6 +172,7 @@ func (l *boltDBappLogs) LogsSince(t time.Time) ([]string, error) {
 	}()
 
 	logs := make([]string, 0)
+	var lastKey []byte
 
 	err = db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(l.bucket)
@@ -135,28 +181,109 @@ func (l *boltDBappLogs) LogsSince(t time.Time) ([]string, error) {
 
 		v := b.Get(parsedTime)
 		if v == nil {
-			return iterateFromBeginning(c, parsedTime, &logs)
+			return iterateFromBeginning(c, parsedTime, &logs, &lastKey)
 		}
 		c.Seek(parsedTime)
-		return iterateFromKey(c, &logs)
+		return iterateFromKey(c, &logs, &lastKey)
 	})
 
-	return logs, err
+	return logs, lastKey, err
+}
+
+// Tail implements LogStore by fanning out every subsequent Write/Store
+// call to subscriber channels in-process. The subscription is registered
+// before the historical snapshot is taken (rather than after), so a line
+// written in between is never dropped; it arrives on sub and is filtered
+// out if the snapshot already contains it.
+func (l *boltDBappLogs) Tail(ctx context.Context, t time.Time) (<-chan string, error) {
+	sub := make(chan logEvent, tailChanBuffer)
+	l.subMu.Lock()
+	l.subs = append(l.subs, sub)
+	l.subMu.Unlock()
+
+	historical, lastKey, err := l.logsSinceWithLastKey(t)
+	if err != nil {
+		l.unsubscribe(sub)
+		return nil, err
+	}
+
+	cutoff := t
+	if lastKey != nil {
+		if parsed, err := bytesToTime(lastKey); err == nil {
+			cutoff = parsed
+		}
+	}
+
+	out := make(chan string, tailChanBuffer)
+	go func() {
+		defer close(out)
+		defer l.unsubscribe(sub)
+
+		for _, line := range historical {
+			select {
+			case out <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		for {
+			select {
+			case ev := <-sub:
+				if !ev.t.After(cutoff) {
+					continue // already delivered as part of the historical snapshot
+				}
+				select {
+				case out <- ev.line:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (l *boltDBappLogs) broadcast(t time.Time, line string) {
+	l.subMu.Lock()
+	defer l.subMu.Unlock()
+	for _, sub := range l.subs {
+		select {
+		case sub <- logEvent{t, line}:
+		default: // a stalled subscriber shouldn't block a Write
+		}
+	}
 }
 
-func iterateFromKey(c *bbolt.Cursor, logs *[]string) error {
+func (l *boltDBappLogs) unsubscribe(sub chan logEvent) {
+	l.subMu.Lock()
+	defer l.subMu.Unlock()
+	for i, s := range l.subs {
+		if s == sub {
+			l.subs = append(l.subs[:i], l.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+func iterateFromKey(c *bbolt.Cursor, logs *[]string, lastKey *[]byte) error {
 	for k, v := c.Next(); k != nil; k, v = c.Next() {
 		*logs = append(*logs, string(v))
+		*lastKey = k
 	}
 	return nil
 }
 
-func iterateFromBeginning(c *bbolt.Cursor, parsedTime []byte, logs *[]string) error {
+func iterateFromBeginning(c *bbolt.Cursor, parsedTime []byte, logs *[]string, lastKey *[]byte) error {
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		if bytes.Compare(k, parsedTime) < 0 {
 			continue
 		}
 		*logs = append(*logs, string(v))
+		*lastKey = k
 	}
 
 	return nil
@@ -164,4 +291,4 @@ func iterateFromBeginning(c *bbolt.Cursor, parsedTime []byte, logs *[]string) er
 
 func bytesToTime(b []byte) (time.Time, error) {
 	return time.Parse(time.RFC3339Nano, string(b))
-}
\ No newline at end of file
+}
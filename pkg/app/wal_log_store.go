@@ -0,0 +1,549 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	walSegmentPrefix = "app-"
+	walSegmentSuffix = ".wal"
+
+	// walRecordHeaderLen is the size of a record's [timestamp][length]
+	// header: an 8-byte big-endian unix-nanos timestamp followed by a
+	// 4-byte big-endian payload length.
+	walRecordHeaderLen = 8 + 4
+
+	defaultMaxSegmentSize = 16 * 1024 * 1024
+	defaultFsyncInterval  = time.Second
+	defaultRetention      = 7 * 24 * time.Hour
+	defaultCompactEvery   = time.Hour
+)
+
+// WALConfig tunes a "wal" LogStore. The zero value is not valid; use
+// DefaultWALConfig and override individual fields.
+type WALConfig struct {
+	// MaxSegmentSize is the size a segment may reach before a new one is
+	// started.
+	MaxSegmentSize int64
+	// FsyncInterval is how often buffered writes are flushed and synced
+	// to disk.
+	FsyncInterval time.Duration
+	// Retention is how long a segment is kept after it stops being the
+	// active segment, before the background compactor deletes it.
+	Retention time.Duration
+}
+
+// DefaultWALConfig returns sane defaults: 16 MiB segments, fsync once a
+// second, and a week of retention.
+func DefaultWALConfig() WALConfig {
+	return WALConfig{
+		MaxSegmentSize: defaultMaxSegmentSize,
+		FsyncInterval:  defaultFsyncInterval,
+		Retention:      defaultRetention,
+	}
+}
+
+// walLogStore is an append-only, segmented-log LogStore, in the style of
+// tendermint's autofile: each Write appends a length-prefixed record to
+// the current segment, fsync is batched on an interval instead of
+// happening per-write, and segments roll over once they exceed
+// MaxSegmentSize. Segments are named app-<startNanos>.wal, so LogsSince
+// can binary search the segment filenames for a starting point instead
+// of always scanning from the beginning.
+type walLogStore struct {
+	dir string
+	cfg WALConfig
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	segSize int64
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+// NewWALLogStore returns a wal-backed LogStore rooted at path/appName,
+// with the given configuration.
+func NewWALLogStore(path, appName string, cfg WALConfig) (LogStore, error) {
+	dir := filepath.Join(path, appName)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	l := &walLogStore{dir: dir, cfg: cfg, closeCh: make(chan struct{})}
+	if err := l.openActiveSegment(); err != nil {
+		return nil, err
+	}
+
+	go l.fsyncLoop()
+	go l.compactLoop()
+
+	return l, nil
+}
+
+func newWALLogStore(path, appName string) (LogStore, error) {
+	return NewWALLogStore(path, appName, DefaultWALConfig())
+}
+
+// openActiveSegment opens the newest existing segment for appending, or
+// starts a fresh one if the directory is empty.
+func (l *walLogStore) openActiveSegment() error {
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+
+	if len(segments) == 0 {
+		return l.startSegment(time.Now().UnixNano())
+	}
+
+	last := segments[len(segments)-1]
+	f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	l.f = f
+	l.w = bufio.NewWriter(f)
+	l.segSize = info.Size()
+	return nil
+}
+
+// startSegment closes the current segment, if any, and opens a new one
+// named after startNanos.
+func (l *walLogStore) startSegment(startNanos int64) error {
+	if l.f != nil {
+		if err := l.flushLocked(); err != nil {
+			return err
+		}
+		if err := l.f.Close(); err != nil {
+			return err
+		}
+	}
+
+	name := fmt.Sprintf("%s%d%s", walSegmentPrefix, startNanos, walSegmentSuffix)
+	f, err := os.OpenFile(filepath.Join(l.dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	l.f = f
+	l.w = bufio.NewWriter(f)
+	l.segSize = 0
+	return nil
+}
+
+// Write implements io.Writer. The incoming log line is expected to carry
+// its own RFC3339Nano timestamp at bytes [1:36], same as the bbolt
+// backend relies on, so entries are indexed by the time they claim to
+// have been logged at rather than the time Write was called.
+func (l *walLogStore) Write(p []byte) (int, error) {
+	if len(p) < 36 {
+		return 0, fmt.Errorf("log entry too short to contain a timestamp")
+	}
+	t, err := bytesToTime(p[1:36])
+	if err != nil {
+		return 0, err
+	}
+	if err := l.appendRecord(t, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Store implements LogStore
+func (l *walLogStore) Store(t time.Time, s string) error {
+	return l.appendRecord(t, []byte(s))
+}
+
+func (l *walLogStore) appendRecord(t time.Time, payload []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	need := int64(walRecordHeaderLen + len(payload))
+	if l.segSize > 0 && l.segSize+need > l.cfg.MaxSegmentSize {
+		if err := l.startSegment(t.UnixNano()); err != nil {
+			return err
+		}
+	}
+
+	var hdr [walRecordHeaderLen]byte
+	binary.BigEndian.PutUint64(hdr[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(payload)))
+
+	if _, err := l.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := l.w.Write(payload); err != nil {
+		return err
+	}
+
+	l.segSize += need
+	return nil
+}
+
+func (l *walLogStore) flushLocked() error {
+	if l.w == nil {
+		return nil
+	}
+	return l.w.Flush()
+}
+
+func (l *walLogStore) fsyncLoop() {
+	ticker := time.NewTicker(l.cfg.FsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.closeCh:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if err := l.flushLocked(); err == nil {
+				l.f.Sync() // nolint: errcheck
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// compactLoop periodically deletes segments whose end (the start of the
+// next segment) is older than cfg.Retention. The active segment is never
+// deleted.
+func (l *walLogStore) compactLoop() {
+	ticker := time.NewTicker(defaultCompactEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.closeCh:
+			return
+		case <-ticker.C:
+			l.compact()
+		}
+	}
+}
+
+func (l *walLogStore) compact() {
+	segments, err := listSegments(l.dir)
+	if err != nil || len(segments) < 2 {
+		return
+	}
+
+	l.mu.Lock()
+	activePath := l.f.Name()
+	l.mu.Unlock()
+
+	cutoff := time.Now().Add(-l.cfg.Retention).UnixNano()
+	for i := 0; i < len(segments)-1; i++ {
+		if segments[i].path == activePath {
+			continue
+		}
+		if segments[i+1].startNanos > cutoff {
+			break
+		}
+		os.Remove(segments[i].path) // nolint: errcheck
+	}
+}
+
+// Close flushes and closes the active segment and stops the background
+// fsync/compaction loops.
+func (l *walLogStore) Close() error {
+	l.closeOnce.Do(func() { close(l.closeCh) })
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.flushLocked(); err != nil {
+		return err
+	}
+	return l.f.Close()
+}
+
+// LogsSince implements LogStore. It binary searches the segment
+// filenames (each named after the unix-nanos timestamp of its first
+// record) for the segment that should contain t, then scans it linearly
+// -- skipping any record still older than t -- before returning every
+// record in every later segment in full.
+func (l *walLogStore) LogsSince(t time.Time) ([]string, error) {
+	logs, _, err := l.logsSinceWithCutoff(t)
+	return logs, err
+}
+
+// logsSinceWithCutoff is LogsSince plus the unix-nanos timestamp of the
+// last entry it read (or since, if nothing matched), so Tail can filter
+// out live events already covered by the historical snapshot.
+func (l *walLogStore) logsSinceWithCutoff(t time.Time) ([]string, int64, error) {
+	l.mu.Lock()
+	err := l.flushLocked()
+	l.mu.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	since := t.UnixNano()
+
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(segments) == 0 {
+		return []string{}, since, nil
+	}
+
+	start := sort.Search(len(segments), func(i int) bool {
+		return segments[i].startNanos > since
+	})
+	if start > 0 {
+		start--
+	}
+
+	logs := make([]string, 0)
+	cutoff := since
+	for i := start; i < len(segments); i++ {
+		entries, err := readSegment(segments[i].path)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, e := range entries {
+			if i == start && e.nanos < since {
+				continue
+			}
+			logs = append(logs, string(e.payload))
+			cutoff = e.nanos
+		}
+	}
+	return logs, cutoff, nil
+}
+
+// Tail implements LogStore. Unlike boltDBappLogs, a reader may be a
+// separate process from the one writing (e.g. skywire-cli tailing a
+// node's logs over RPC), so new entries are picked up by watching the
+// active segment with fsnotify instead of an in-process fan-out. The
+// watcher is set up and the active segment's path and current size
+// captured before the historical snapshot is taken (rather than after),
+// so a line written in between is never dropped; it's read again off
+// disk and filtered out by tailLoop if the snapshot already contains it.
+func (l *walLogStore) Tail(ctx context.Context, t time.Time) (<-chan string, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(l.dir); err != nil {
+		_ = watcher.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	path := l.activePath()
+	offset, err := segmentSize(path)
+	if err != nil {
+		_ = watcher.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	historical, cutoff, err := l.logsSinceWithCutoff(t)
+	if err != nil {
+		_ = watcher.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	out := make(chan string, tailChanBuffer)
+	go l.tailLoop(ctx, watcher, out, historical, path, offset, cutoff)
+	return out, nil
+}
+
+func (l *walLogStore) tailLoop(ctx context.Context, watcher *fsnotify.Watcher, out chan<- string, historical []string, path string, offset, cutoff int64) {
+	defer close(out)
+	defer watcher.Close() // nolint: errcheck
+
+	for _, line := range historical {
+		select {
+		case out <- line:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if ev.Name != l.activePath() {
+				continue // a now-closed segment rolled over; nothing new to read from it
+			}
+			if ev.Name != path {
+				// the active segment rolled over to a new, empty file: start
+				// reading it from the beginning instead of carrying over an
+				// offset that belonged to the old, now-closed segment.
+				path = ev.Name
+				offset = 0
+			}
+
+			entries, newOffset, err := readSegmentFrom(path, offset)
+			if err != nil {
+				return
+			}
+			offset = newOffset
+			for _, e := range entries {
+				if e.nanos <= cutoff {
+					continue // already delivered as part of the historical snapshot
+				}
+				select {
+				case out <- string(e.payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *walLogStore) activePath() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Name()
+}
+
+func segmentSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// readSegmentFrom reads every complete record in path starting at offset,
+// stopping without error at the first short read -- which, for the
+// active segment, just means the writer hasn't flushed past that point
+// yet. It returns the offset to resume from next time.
+func readSegmentFrom(path string, offset int64) ([]walEntry, int64, error) {
+	f, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close() // nolint: errcheck
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+
+	r := bufio.NewReader(f)
+	entries := make([]walEntry, 0)
+	consumed := int64(0)
+	for {
+		var hdr [walRecordHeaderLen]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			break
+		}
+
+		length := binary.BigEndian.Uint32(hdr[8:])
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+
+		nanos := int64(binary.BigEndian.Uint64(hdr[:8]))
+		entries = append(entries, walEntry{nanos: nanos, payload: payload})
+		consumed += walRecordHeaderLen + int64(length)
+	}
+
+	return entries, offset + consumed, nil
+}
+
+type walSegment struct {
+	startNanos int64
+	path       string
+}
+
+// listSegments returns every app-<nanos>.wal segment in dir, sorted by
+// ascending startNanos.
+func listSegments(dir string) ([]walSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]walSegment, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, walSegmentPrefix) || !strings.HasSuffix(name, walSegmentSuffix) {
+			continue
+		}
+		numPart := strings.TrimSuffix(strings.TrimPrefix(name, walSegmentPrefix), walSegmentSuffix)
+		nanos, err := strconv.ParseInt(numPart, 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, walSegment{startNanos: nanos, path: filepath.Join(dir, name)})
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].startNanos < segments[j].startNanos })
+	return segments, nil
+}
+
+type walEntry struct {
+	nanos   int64
+	payload []byte
+}
+
+// readSegment reads every record out of the segment at path, in order. A
+// short read on the trailing record -- the torn record an unclean
+// shutdown can leave on what was then the active segment -- ends the
+// scan without error, same as readSegmentFrom already does for the
+// active segment; otherwise one torn tail record would fail LogsSince
+// (and therefore Tail) for the whole store until the segment aged out.
+func readSegment(path string) ([]walEntry, error) {
+	f, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint: errcheck
+
+	r := bufio.NewReader(f)
+	entries := make([]walEntry, 0)
+	for {
+		var hdr [walRecordHeaderLen]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			break
+		}
+
+		nanos := int64(binary.BigEndian.Uint64(hdr[:8]))
+		n := binary.BigEndian.Uint32(hdr[8:])
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+
+		entries = append(entries, walEntry{nanos: nanos, payload: payload})
+	}
+	return entries, nil
+}
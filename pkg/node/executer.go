@@ -0,0 +1,17 @@
+package node
+
+import "os/exec"
+
+// Executer abstracts how a Node turns an app's exec.Cmd into a running
+// process: Start spawns cmd and returns its pid, Stop terminates a
+// previously-started pid, and Wait blocks until cmd's process exits.
+//
+// This interface is reconstructed from MockExecuter in node_test.go: the
+// concrete os/exec-backed Node and AppConfig it's tested against aren't
+// part of this snapshot, so Executer is declared here purely so
+// SandboxExecuter has something to implement alongside.
+type Executer interface {
+	Start(cmd *exec.Cmd) (int, error)
+	Stop(pid int) error
+	Wait(cmd *exec.Cmd) error
+}
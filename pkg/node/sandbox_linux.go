@@ -0,0 +1,501 @@
+//go:build linux
+// +build linux
+
+package node
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxReexecEnv and sandboxReexecDirEnv mark a re-exec of this same
+// binary as "you are the sandboxed app, not the node": see init() below.
+// Go's os/exec gives no hook to run code between fork and exec, so --
+// the same way runc does it -- SandboxExecuter has the child re-exec
+// this binary inside the freshly unshared namespaces, and that re-exec
+// (detected via these env vars) ptrace-supervises one more re-exec
+// (sandboxReexecInnerEnv, below), which is the one that sets up the
+// mount jail, installs the seccomp filter, and finally exec's into the
+// real app binary.
+const (
+	sandboxReexecEnv    = "SKYWIRE_SANDBOX_EXEC"
+	sandboxReexecDirEnv = "SKYWIRE_SANDBOX_EXEC_DIR"
+
+	// sandboxReexecInnerEnv and sandboxReexecInnerDirEnv mark the
+	// innermost re-exec: the one that actually becomes appBinary. It's
+	// launched and ptrace-supervised by reexecSandboxedApp (via
+	// superviseAppExec) rather than exec-ed directly, so the seccomp
+	// filter it installs can route SYS_EXECVE through SECCOMP_RET_TRACE
+	// instead of allowing it outright: the supervisor lets the one
+	// execve that becomes appBinary through, but kills the process if
+	// appBinary itself ever tries another.
+	sandboxReexecInnerEnv    = "SKYWIRE_SANDBOX_EXEC_INNER"
+	sandboxReexecInnerDirEnv = "SKYWIRE_SANDBOX_EXEC_INNER_DIR"
+)
+
+func init() {
+	if target := os.Getenv(sandboxReexecInnerEnv); target != "" {
+		reexecIntoApp(target, os.Getenv(sandboxReexecInnerDirEnv))
+		os.Exit(1)
+	}
+
+	target := os.Getenv(sandboxReexecEnv)
+	if target == "" {
+		return
+	}
+	reexecSandboxedApp(target, os.Getenv(sandboxReexecDirEnv))
+	// reexecSandboxedApp only returns on failure: on success it re-raises
+	// appBinary's own exit as its own and never returns at all.
+	os.Exit(1)
+}
+
+// allowedSyscalls is the full set of syscalls the app framing protocol
+// (length-prefixed frames read from and written to a single inherited
+// pipe/socket fd) needs, plus the syscalls the Go runtime itself makes
+// before main() runs and while polling any socket/pipe fd. Anything not
+// listed here, and not in tracedSyscalls below, traps a SIGSYS kill.
+var allowedSyscalls = []int{
+	// app framing protocol: read/write its connection, exit.
+	unix.SYS_READ,
+	unix.SYS_WRITE,
+	unix.SYS_CLOSE,
+	unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP,
+
+	// Go netpoller, needed by any socket/pipe-based I/O.
+	unix.SYS_EPOLL_CREATE1,
+	unix.SYS_EPOLL_CTL,
+	unix.SYS_EPOLL_WAIT,
+	unix.SYS_EPOLL_PWAIT,
+
+	// Go runtime startup and scheduling, before and after main().
+	unix.SYS_MMAP,
+	unix.SYS_MUNMAP,
+	unix.SYS_BRK,
+	unix.SYS_RT_SIGRETURN,
+	unix.SYS_RT_SIGACTION,
+	unix.SYS_RT_SIGPROCMASK,
+	unix.SYS_SIGALTSTACK,
+	unix.SYS_ARCH_PRCTL,
+	unix.SYS_SET_TID_ADDRESS,
+	unix.SYS_CLONE,
+	unix.SYS_FUTEX,
+	unix.SYS_SCHED_GETAFFINITY,
+	unix.SYS_CLOCK_GETTIME,
+	unix.SYS_NANOSLEEP,
+	unix.SYS_GETRANDOM,
+	unix.SYS_OPENAT,
+	unix.SYS_MADVISE,
+	unix.SYS_FCNTL,
+	unix.SYS_GETRLIMIT,
+	unix.SYS_GETTID,
+	unix.SYS_GETPID,
+}
+
+// tracedSyscalls lists syscalls the filter routes through
+// SECCOMP_RET_TRACE instead of an outright SECCOMP_RET_ALLOW or kill:
+// the ptrace supervisor in superviseAppExec decides case by case whether
+// to let each attempt through. SYS_EXECVE is here, not in
+// allowedSyscalls, because the one legitimate execve (reexecIntoApp
+// becoming appBinary) and any further one appBinary might attempt need
+// different answers, and a plain allow-list can't tell them apart.
+var tracedSyscalls = []int{
+	unix.SYS_EXECVE,
+}
+
+// applySandbox points cmd at this same binary so it re-execs into
+// appBinary once it's inside a fresh user+mount+pid namespace (see
+// init() above), and tags it with the view it should bind-mount.
+func applySandbox(cmd *exec.Cmd, kind SandboxKind, appBinary, appDir string) error {
+	if kind != SandboxSeccomp {
+		return fmt.Errorf("unsupported sandbox kind on linux: %s", kind)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd.Args = append([]string{self}, cmd.Args...)
+	cmd.Path = self
+	cmd.Env = append(cmd.Env, sandboxReexecEnv+"="+appBinary, sandboxReexecDirEnv+"="+appDir)
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+	}
+
+	return nil
+}
+
+// reexecSandboxedApp is the re-exec entrypoint: it launches and
+// ptrace-supervises the inner re-exec that becomes appBinary (see
+// superviseAppExec). It deliberately does none of the mount-jail setup
+// itself -- that happens in reexecIntoApp, inside the supervised child,
+// so that reexecSandboxedApp (which must keep running as the supervisor)
+// never pivot_roots away the filesystem it needs to resolve and launch
+// that child from. It only returns on a setup failure that happens
+// before the inner process starts; once it's running, reexecSandboxedApp
+// re-raises its exit as its own and never returns.
+func reexecSandboxedApp(appBinary, appDir string) {
+	if err := superviseAppExec(appBinary, appDir); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	}
+}
+
+// reexecIntoApp is the innermost re-exec hop, launched and supervised by
+// superviseAppExec: it bind-mounts a view of just appBinary and appDir,
+// installs the seccomp filter that governs appBinary for the rest of its
+// life, then execs into it. It only returns on a failure before that
+// final exec.
+func reexecIntoApp(appBinary, appDir string) {
+	if err := bindMountAppView(appBinary, appDir); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: failed to set up mount view: %v\n", err)
+		return
+	}
+
+	if err := installSeccompFilter(allowedSyscalls, tracedSyscalls); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: failed to install seccomp filter: %v\n", err)
+		return
+	}
+
+	// os.Args is [self, appBinary, appArg1, appArg2, ...]: the exec'd
+	// process should see exactly the argv the caller originally built,
+	// i.e. everything but self.
+	if err := syscall.Exec(appBinary, os.Args[1:], os.Environ()); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: failed to exec %s: %v\n", appBinary, err)
+	}
+}
+
+// bindMountAppView builds a new root containing nothing but appBinary and
+// appDir (mirrored read-only at their original absolute paths, so the app
+// doesn't need to know it's jailed) and pivot_roots into it, so that --
+// combined with the mount namespace unshared by Cloneflags -- the app
+// can only see its own binary and its own local/<appName> directory, not
+// the rest of the host filesystem. A self bind-mount of just those two
+// paths, as this used to do, leaves every other host path reachable
+// through the still-visible original root; pivot_root is what actually
+// replaces it.
+func bindMountAppView(appBinary, appDir string) error {
+	if err := unix.Mount("", "/", "", unix.MS_PRIVATE|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to make mount namespace private: %w", err)
+	}
+
+	newRoot, err := os.MkdirTemp("", "skywire-sandbox-root")
+	if err != nil {
+		return fmt.Errorf("failed to create new root: %w", err)
+	}
+
+	// newRoot must be a mount point in its own right for pivot_root, and
+	// tmpfs means it (and everything mirrored under it) disappears on its
+	// own once unmounted, with nothing left to clean up on the host.
+	if err := unix.Mount("tmpfs", newRoot, "tmpfs", 0, ""); err != nil {
+		return fmt.Errorf("failed to mount tmpfs for new root: %w", err)
+	}
+
+	for _, path := range []string{appBinary, appDir} {
+		mirror := newRoot + path
+		if err := mirrorReadOnly(path, mirror); err != nil {
+			return err
+		}
+	}
+
+	oldRoot := filepath.Join(newRoot, ".old_root")
+	if err := os.Mkdir(oldRoot, 0700); err != nil {
+		return fmt.Errorf("failed to create old root mount point: %w", err)
+	}
+	if err := unix.PivotRoot(newRoot, oldRoot); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := unix.Chdir("/"); err != nil {
+		return fmt.Errorf("failed to chdir into new root: %w", err)
+	}
+
+	// "/.old_root" now holds the entire former root, host filesystem and
+	// all; detach it so nothing under the new root can reach it anymore,
+	// then drop the now-empty mount point.
+	if err := unix.Unmount("/.old_root", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach old root: %w", err)
+	}
+	if err := os.Remove("/.old_root"); err != nil {
+		return fmt.Errorf("failed to remove old root mount point: %w", err)
+	}
+
+	return nil
+}
+
+// mirrorReadOnly bind-mounts src onto dst, read-only, creating dst (and
+// its parent directories) first -- as either a file or a directory,
+// matching src -- since the target of a bind mount must already exist.
+func mirrorReadOnly(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+
+	if info.IsDir() {
+		if err := os.MkdirAll(dst, 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dst, err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(dst), err)
+		}
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", dst, err)
+		}
+		_ = f.Close() // nolint: errcheck
+	}
+
+	if err := unix.Mount(src, dst, "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("failed to bind mount %s onto %s: %w", src, dst, err)
+	}
+	if err := unix.Mount("", dst, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+		return fmt.Errorf("failed to remount %s read-only: %w", dst, err)
+	}
+
+	return nil
+}
+
+// installSeccompFilter assembles a seccomp-bpf program allowing exactly
+// allowed, routing traced through SECCOMP_RET_TRACE for a ptrace
+// supervisor to decide on, and killing the process on anything else,
+// then loads it for the current thread via prctl(PR_SET_SECCOMP).
+func installSeccompFilter(allowed, traced []int) error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %w", err)
+	}
+
+	prog := seccompProgram(allowed, traced)
+	fprog := unix.SockFprog{
+		Len:    uint16(len(prog)),
+		Filter: &prog[0],
+	}
+
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog)), 0, 0); err != nil {
+		return fmt.Errorf("prctl(PR_SET_SECCOMP): %w", err)
+	}
+
+	return nil
+}
+
+// seccompArchOffset and seccompSyscallNROffset are byte offsets within
+// struct seccomp_data, per linux/seccomp.h.
+const (
+	seccompArchOffset      = 4
+	seccompSyscallNROffset = 0
+)
+
+// seccompAuditArch is the AUDIT_ARCH_* constant matching this binary's
+// own architecture. seccompProgram compares it against seccomp_data.arch
+// before looking at the syscall number at all, so a syscall entered via
+// a different ABI (e.g. the 32-bit or x32 compat entry points on an
+// amd64 kernel) can't alias an allowed 64-bit syscall number to a
+// different syscall.
+var seccompAuditArch = func() uint32 {
+	switch runtime.GOARCH {
+	case "amd64":
+		return unix.AUDIT_ARCH_X86_64
+	case "arm64":
+		return unix.AUDIT_ARCH_AARCH64
+	default:
+		// no seccomp_data.arch value will ever match zero, so every
+		// syscall just falls through the arch check's kill branch.
+		return 0
+	}
+}()
+
+func seccompProgram(allowed, traced []int) []unix.SockFilter {
+	prog := []unix.SockFilter{
+		// load the arch field and kill outright on anything but the
+		// arch this binary was built for: seccomp_data.nr is only
+		// meaningful relative to seccomp_data.arch, and a 32-bit or x32
+		// ABI entry point can alias an allowed 64-bit syscall number to
+		// a completely different syscall.
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: seccompArchOffset},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: seccompAuditArch, Jt: 1, Jf: 0},
+		{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_KILL_PROCESS},
+
+		// load the syscall number into the BPF accumulator
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: seccompSyscallNROffset},
+	}
+
+	for _, nr := range allowed {
+		prog = append(prog,
+			// if accumulator == nr, skip the next (kill) instruction and fall into allow
+			unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: uint32(nr), Jt: 0, Jf: 1},
+			unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ALLOW},
+		)
+	}
+
+	for _, nr := range traced {
+		prog = append(prog,
+			unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: uint32(nr), Jt: 0, Jf: 1},
+			unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_TRACE},
+		)
+	}
+
+	prog = append(prog, unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_KILL_PROCESS})
+	return prog
+}
+
+// superviseAppExec launches appBinary through one more re-exec of this
+// same binary (reexecIntoApp) and ptrace-supervises it for as long as it
+// runs: PTRACE_O_TRACESECCOMP turns every SECCOMP_RET_TRACE stop --
+// every SYS_EXECVE attempt, per tracedSyscalls -- into a PTRACE_EVENT_SECCOMP
+// stop here. The first one is reexecIntoApp itself becoming appBinary
+// and is let through; any further one is appBinary trying to exec
+// something else now that it's past the filter install, and is denied.
+// superviseAppExec never returns on success: it re-raises appBinary's
+// own exit (or kill) as its own, so the original cmd SandboxExecuter.Start
+// launched -- which is this process -- looks to its caller exactly like
+// it had run appBinary directly.
+//
+// self is resolved here, before reexecIntoApp's pivot_root runs: that
+// pivot_root only replaces the calling (inner) process's own root, not
+// this supervisor's, but /proc/self/exe -- which os.Executable() reads
+// -- wouldn't exist in the jail the inner process builds for itself
+// either way, so there's no path left to resolve once it's running.
+//
+// ptrace is per-thread, not per-process: the tracer must be the exact
+// OS thread that forked the tracee, so this locks the calling goroutine
+// to its current thread for as long as it supervises (which, since it
+// never returns except on error, is effectively for good).
+func superviseAppExec(appBinary, appDir string) error {
+	runtime.LockOSThread()
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to resolve self: %w", err)
+	}
+
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), sandboxReexecInnerEnv+"="+appBinary, sandboxReexecInnerDirEnv+"="+appDir)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start supervised re-exec: %w", err)
+	}
+	pid := cmd.Process.Pid
+
+	// cmd.Start left the child stopped at the SIGTRAP ptrace raises right
+	// after its own exec; reap that before arming PTRACE_O_TRACESECCOMP.
+	var status unix.WaitStatus
+	if _, err := unix.Wait4(pid, &status, 0, nil); err != nil {
+		return fmt.Errorf("failed to wait for initial exec-stop: %w", err)
+	}
+	if err := unix.PtraceSetOptions(pid, unix.PTRACE_O_TRACESECCOMP); err != nil {
+		return fmt.Errorf("PTRACE_SETOPTIONS: %w", err)
+	}
+	if err := unix.PtraceCont(pid, 0); err != nil {
+		return fmt.Errorf("PTRACE_CONT: %w", err)
+	}
+
+	sawExecve := false
+	for {
+		if _, err := unix.Wait4(pid, &status, 0, nil); err != nil {
+			return fmt.Errorf("wait4: %w", err)
+		}
+
+		switch {
+		case status.Exited():
+			os.Exit(status.ExitStatus())
+		case status.Signaled():
+			terminateLikeChild(status.Signal())
+		case status.Stopped() && status.StopSignal() == unix.SIGTRAP && status.TrapCause() == unix.PTRACE_EVENT_SECCOMP:
+			if !sawExecve {
+				sawExecve = true
+				if err := unix.PtraceCont(pid, 0); err != nil {
+					return fmt.Errorf("PTRACE_CONT: %w", err)
+				}
+				continue // reexecIntoApp becoming appBinary: let it through
+			}
+			// A second execve: appBinary trying to exec something
+			// else now that it's past the filter install. Injecting
+			// SIGSYS via PTRACE_CONT would just hand the signal to
+			// the tracee's own handler -- which for a Go binary means
+			// the runtime's sigtab treats it as _SigThrow and exits
+			// 0/2 on its own terms, never actually signal-killed, so
+			// isSandboxViolation's WIFSIGNALED check would miss it.
+			// SIGKILL can't be caught or blocked, so it terminates the
+			// tracee unconditionally, the same way SECCOMP_RET_KILL_PROCESS
+			// itself would have.
+			if err := unix.Kill(pid, unix.SIGKILL); err != nil {
+				return fmt.Errorf("failed to kill re-exec attempt: %w", err)
+			}
+		default:
+			// Any other SIGTRAP stop is a ptrace artifact, not a
+			// real signal -- notably, the allowed execve above
+			// raises a second, plain post-exec SIGTRAP stop once it
+			// actually runs, on top of the PTRACE_EVENT_SECCOMP stop
+			// already handled above. Swallow those; forward anything
+			// else (a real signal bound for the tracee) as-is.
+			sig := 0
+			if status.Stopped() && status.StopSignal() != unix.SIGTRAP {
+				sig = int(status.StopSignal())
+			}
+			if err := unix.PtraceCont(pid, sig); err != nil {
+				return fmt.Errorf("PTRACE_CONT: %w", err)
+			}
+		}
+	}
+}
+
+// terminateLikeChild ends this process the same way a traced child just
+// ended, so the original cmd SandboxExecuter is watching -- which is
+// this process -- reports an equivalent outcome to what cmd.Wait would
+// have seen had this process become appBinary directly.
+//
+// This process is PID 1 of its own PID namespace (see applySandbox's
+// CLONE_NEWPID), and the kernel refuses to deliver an unhandled signal --
+// SIGKILL included -- that a PID-namespace init sends to itself; only a
+// signal from an ancestor namespace, or one the kernel raises directly
+// (SECCOMP_RET_KILL_PROCESS, a real fault), can actually kill it. So
+// rather than re-signaling itself and relying on that working, this exits
+// with the shell convention for "killed by signal N", 128+N -- which
+// isSandboxViolation knows to treat the same as a genuine WIFSIGNALED
+// death.
+func terminateLikeChild(sig syscall.Signal) {
+	os.Exit(128 + int(sig))
+}
+
+func stopPid(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+// isSandboxViolation reports whether err is the process exiting because
+// it was killed for a sandbox violation: either the seccomp filter
+// killing it outright for an unlisted syscall (SIGSYS), or the ptrace
+// supervisor in superviseAppExec killing it for a second SYS_EXECVE
+// (SIGKILL). The process watched here is PID 1 of its own PID namespace
+// (see applySandbox), so a supervisor-initiated kill surfaces as the
+// 128+signal exit convention from terminateLikeChild rather than a
+// genuine WIFSIGNALED -- both are checked here.
+func isSandboxViolation(err error) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return false
+	}
+	if status.Signaled() {
+		return status.Signal() == syscall.SIGSYS || status.Signal() == syscall.SIGKILL
+	}
+	return status.Exited() && (status.ExitStatus() == 128+int(syscall.SIGSYS) || status.ExitStatus() == 128+int(syscall.SIGKILL))
+}
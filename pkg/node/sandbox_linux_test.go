@@ -0,0 +1,202 @@
+//go:build linux
+// +build linux
+
+package node
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// trivialAppSrc exercises exactly the kind of app allowedSyscalls needs to
+// support: it writes to its inherited stdout and exits cleanly, nothing
+// more.
+const trivialAppSrc = `package main
+
+import "os"
+
+func main() {
+	os.Stdout.WriteString("ok\n")
+}
+`
+
+// TestSandboxSeccompRunsTrivialApp builds a trivial Go binary and runs it
+// through SandboxExecuter with SandboxSeccomp, asserting it runs to
+// completion instead of being killed by the syscall filter. This is the
+// regression test for the filter killing every sandboxed app on its very
+// first instruction, the exec into the app binary (now let through once
+// via tracedSyscalls and the superviseAppExec supervisor), and for the
+// Go-runtime/epoll syscalls any real app needs just to reach main().
+//
+// User namespaces aren't available in every environment this runs in
+// (containers without CAP_SYS_ADMIN, a restrictive
+// kernel.unprivileged_userns_clone, etc.), so the test skips rather than
+// fails when CLONE_NEWUSER is denied.
+func TestSandboxSeccompRunsTrivialApp(t *testing.T) {
+	dir := t.TempDir()
+
+	srcPath := filepath.Join(dir, "main.go")
+	require.NoError(t, os.WriteFile(srcPath, []byte(trivialAppSrc), 0o600))
+
+	appBinary := filepath.Join(dir, "app")
+	build := exec.Command("go", "build", "-o", appBinary, srcPath)
+	build.Env = os.Environ()
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	appDir := filepath.Join(dir, "appdir")
+	require.NoError(t, os.Mkdir(appDir, 0o755))
+
+	exe := NewSandboxExecuter(SandboxSeccomp, appBinary, appDir, nil, nil)
+
+	cmd := exec.Command(appBinary)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	pid, err := exe.Start(cmd)
+	if err != nil && userNamespacesUnavailable(err) {
+		t.Skipf("user namespaces unavailable in this environment: %v", err)
+	}
+	require.NoError(t, err)
+	require.Greater(t, pid, 0)
+
+	err = exe.Wait(cmd)
+	if err != nil && userNamespacesUnavailable(err) {
+		t.Skipf("user namespaces unavailable in this environment: %v", err)
+	}
+	require.NoError(t, err, "app did not run to completion, stderr: %s", stderr.String())
+	require.Equal(t, "ok\n", stdout.String())
+}
+
+// userNamespacesUnavailable reports whether err looks like CLONE_NEWUSER
+// was denied by the host/kernel rather than the sandbox itself being
+// broken, so the caller can skip instead of failing in that environment.
+func userNamespacesUnavailable(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "operation not permitted") || strings.Contains(msg, "invalid argument")
+}
+
+// hostFileReadAppSrc tries to read a file well outside appBinary/appDir
+// and reports whether that succeeded, so the test can assert on the
+// result from the parent without relying on a SIGSYS kill.
+const hostFileReadAppSrc = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if _, err := os.ReadFile("/etc/hostname"); err != nil {
+		fmt.Println("READ_FAILED:", err)
+		return
+	}
+	fmt.Println("READ_OK")
+}
+`
+
+// TestSandboxSeccompHidesHostFilesystem is the regression test for
+// bindMountAppView self-bind-mounting appBinary/appDir over themselves
+// without ever pivot_rooting away from the real root: that left every
+// other host path, including files with no bearing on the app at all,
+// reachable from inside the "sandboxed" process.
+func TestSandboxSeccompHidesHostFilesystem(t *testing.T) {
+	dir := t.TempDir()
+
+	srcPath := filepath.Join(dir, "main.go")
+	require.NoError(t, os.WriteFile(srcPath, []byte(hostFileReadAppSrc), 0o600))
+
+	appBinary := filepath.Join(dir, "app")
+	build := exec.Command("go", "build", "-o", appBinary, srcPath)
+	build.Env = os.Environ()
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	appDir := filepath.Join(dir, "appdir")
+	require.NoError(t, os.Mkdir(appDir, 0o755))
+
+	exe := NewSandboxExecuter(SandboxSeccomp, appBinary, appDir, nil, nil)
+
+	cmd := exec.Command(appBinary)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	pid, err := exe.Start(cmd)
+	if err != nil && userNamespacesUnavailable(err) {
+		t.Skipf("user namespaces unavailable in this environment: %v", err)
+	}
+	require.NoError(t, err)
+	require.Greater(t, pid, 0)
+
+	err = exe.Wait(cmd)
+	if err != nil && userNamespacesUnavailable(err) {
+		t.Skipf("user namespaces unavailable in this environment: %v", err)
+	}
+	require.NoError(t, err, "app did not run to completion, stderr: %s", stderr.String())
+	require.Contains(t, stdout.String(), "READ_FAILED", "app could read /etc/hostname from inside the sandbox")
+}
+
+// reexecAppSrc tries to exec into /bin/echo once it's running, so the
+// test can assert the seccomp/ptrace supervisor kills it rather than
+// letting a second execve through.
+const reexecAppSrc = `package main
+
+import "syscall"
+
+func main() {
+	_ = syscall.Exec("/bin/echo", []string{"/bin/echo", "pwned"}, nil)
+	// only reached if the exec above somehow failed instead of being killed
+	select {}
+}
+`
+
+// TestSandboxSeccompKillsSecondExecve is the regression test for
+// allowedSyscalls unconditionally allow-listing SYS_EXECVE: since a
+// seccomp-bpf filter persists across execve, that let the sandboxed app
+// re-exec into anything it liked (e.g. a shell) after the one legitimate
+// exec into appBinary, defeating the syscall filter entirely. The
+// supervisor in superviseAppExec now lets only that first exec through
+// and kills the process on any further one.
+func TestSandboxSeccompKillsSecondExecve(t *testing.T) {
+	dir := t.TempDir()
+
+	srcPath := filepath.Join(dir, "main.go")
+	require.NoError(t, os.WriteFile(srcPath, []byte(reexecAppSrc), 0o600))
+
+	appBinary := filepath.Join(dir, "app")
+	build := exec.Command("go", "build", "-o", appBinary, srcPath)
+	build.Env = os.Environ()
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	appDir := filepath.Join(dir, "appdir")
+	require.NoError(t, os.Mkdir(appDir, 0o755))
+
+	exe := NewSandboxExecuter(SandboxSeccomp, appBinary, appDir, nil, nil)
+
+	cmd := exec.Command(appBinary)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	pid, err := exe.Start(cmd)
+	if err != nil && userNamespacesUnavailable(err) {
+		t.Skipf("user namespaces unavailable in this environment: %v", err)
+	}
+	require.NoError(t, err)
+	require.Greater(t, pid, 0)
+
+	err = exe.Wait(cmd)
+	if err != nil && userNamespacesUnavailable(err) {
+		t.Skipf("user namespaces unavailable in this environment: %v", err)
+	}
+	require.True(t, isSandboxViolation(err), "expected a sandbox violation, got: %v (stdout: %q)", err, stdout.String())
+}
@@ -0,0 +1,69 @@
+//go:build !linux
+// +build !linux
+
+package node
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// sandboxUnprivilegedUser is the dedicated account apps are dropped to
+// when sandboxed with SandboxChroot; it must exist on the host and own
+// no more than the app binaries it's meant to run.
+const sandboxUnprivilegedUser = "skywire-app"
+
+func sandboxUser() (uid, gid uint32, err error) {
+	u, err := user.Lookup(sandboxUnprivilegedUser)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	uid64, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid64, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(uid64), uint32(gid64), nil
+}
+
+// applySandbox has no seccomp-bpf equivalent outside Linux, so
+// SandboxChroot is the only supported kind here: it drops the app to a
+// dedicated, unprivileged uid/gid and chroots it into appDir, which is
+// expected to already contain (or be bind-mounted/symlinked to) the app
+// binary and its own local/<appName> dir. Where the platform doesn't
+// support chroot for an unprivileged process either (iOS, wasm), callers
+// should fall back to SandboxNone.
+func applySandbox(cmd *exec.Cmd, kind SandboxKind, appBinary, appDir string) error {
+	if kind != SandboxChroot {
+		return fmt.Errorf("unsupported sandbox kind on this platform: %s", kind)
+	}
+
+	uid, gid, err := sandboxUser()
+	if err != nil {
+		return fmt.Errorf("failed to resolve a dedicated sandbox uid/gid: %w", err)
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Chroot:     appDir,
+		Credential: &syscall.Credential{Uid: uid, Gid: gid},
+	}
+	return nil
+}
+
+func stopPid(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+// isSandboxViolation is conservative outside Linux: without seccomp
+// there's no syscall-filter kill signal to recognize, so violations
+// aren't distinguished from an ordinary crash.
+func isSandboxViolation(err error) bool {
+	return false
+}
@@ -0,0 +1,364 @@
+//go:build !no_ci
+// +build !no_ci
+
+package snet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skycoin/dmsg/cipher"
+	"github.com/skycoin/skycoin/src/util/logging"
+
+	"github.com/skycoin/skywire/pkg/snet/kad"
+)
+
+const (
+	// lookupAlpha bounds how many peers an iterative FIND_NODE lookup
+	// queries concurrently per round, per the Kademlia paper.
+	lookupAlpha = 3
+
+	lookupRPCTimeout  = 5 * time.Second
+	defaultRepublish  = 10 * time.Minute
+	bootstrapInterval = time.Minute
+)
+
+// DiscoveryPubKeyTable resolves remote addresses through a Kademlia-style
+// discovery mesh instead of a static file: on construction it pings a
+// configured list of bootnodes to seed its routing table, an unknown key
+// is resolved with an iterative FIND_NODE lookup, and a fresh local record
+// is periodically signed (see republishLoop for what is and isn't sent).
+type DiscoveryPubKeyTable struct {
+	srv *kad.Server
+	log *logging.Logger
+
+	localPK       cipher.PubKey
+	localSK       cipher.SecKey
+	localTCPAddr  string
+	localDiscAddr string
+	seq           uint64 // atomic
+
+	bootnodes []*net.UDPAddr
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+// NewDiscoveryPubKeyTable starts a discovery node listening on conn. It
+// pings each of bootnodes in the background to populate its table and
+// republishes its own record every republish interval (zero selects a
+// sane default).
+func NewDiscoveryPubKeyTable(
+	localPK cipher.PubKey,
+	localSK cipher.SecKey,
+	conn *net.UDPConn,
+	localTCPAddr string,
+	bootnodes []string,
+	republish time.Duration,
+	log *logging.Logger,
+) (*DiscoveryPubKeyTable, error) {
+	if republish <= 0 {
+		republish = defaultRepublish
+	}
+
+	bootAddrs := make([]*net.UDPAddr, 0, len(bootnodes))
+	for _, b := range bootnodes {
+		addr, err := net.ResolveUDPAddr("udp", b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootnode address %q: %w", b, err)
+		}
+		bootAddrs = append(bootAddrs, addr)
+	}
+
+	table := kad.NewTable(localPK)
+	srv := kad.NewServer(localPK, localSK, conn, table, log)
+
+	d := &DiscoveryPubKeyTable{
+		srv:           srv,
+		log:           log,
+		localPK:       localPK,
+		localSK:       localSK,
+		localTCPAddr:  localTCPAddr,
+		localDiscAddr: conn.LocalAddr().String(),
+		bootnodes:     bootAddrs,
+		closeCh:       make(chan struct{}),
+	}
+
+	go func() {
+		if err := srv.Serve(); err != nil {
+			d.logf("discovery server stopped: %v", err)
+		}
+	}()
+	go d.bootstrapLoop()
+	go d.republishLoop(republish)
+
+	return d, nil
+}
+
+// Close stops the discovery node.
+func (d *DiscoveryPubKeyTable) Close() error {
+	d.closeOnce.Do(func() { close(d.closeCh) })
+	return d.srv.Close()
+}
+
+// RemoteAddr returns the TCP address cached for remotePK, or "" if it
+// isn't known yet. It never blocks; use Resolve to trigger a lookup.
+func (d *DiscoveryPubKeyTable) RemoteAddr(remotePK cipher.PubKey) string {
+	r, ok := d.srv.Table().Get(remotePK)
+	if !ok {
+		return ""
+	}
+	return r.TCPAddr
+}
+
+// RemotePK returns the public key last seen announcing address, or a
+// null key if none is known.
+func (d *DiscoveryPubKeyTable) RemotePK(address string) cipher.PubKey {
+	pk, ok := d.srv.Table().ByAddr(address)
+	if !ok {
+		return cipher.PubKey{}
+	}
+	return pk
+}
+
+// Count returns the number of records currently known.
+func (d *DiscoveryPubKeyTable) Count() int {
+	return d.srv.Table().Count()
+}
+
+// Resolve returns the TCP address for remotePK, running an iterative
+// FIND_NODE lookup against the mesh if it isn't already cached.
+func (d *DiscoveryPubKeyTable) Resolve(ctx context.Context, remotePK cipher.PubKey) (string, error) {
+	if r, ok := d.srv.Table().Get(remotePK); ok {
+		return r.TCPAddr, nil
+	}
+
+	if err := d.lookup(ctx, remotePK); err != nil {
+		return "", err
+	}
+
+	r, ok := d.srv.Table().Get(remotePK)
+	if !ok {
+		return "", ErrUnknownRemote
+	}
+	return r.TCPAddr, nil
+}
+
+// lookup runs a standard iterative Kademlia lookup for target: each round
+// it queries the lookupAlpha closest not-yet-queried peers and folds
+// their answers into the routing table, stopping once the target is
+// found, the queried set stops growing, or ctx is done.
+func (d *DiscoveryPubKeyTable) lookup(ctx context.Context, target cipher.PubKey) error {
+	queried := make(map[string]bool)
+
+	for {
+		if _, ok := d.srv.Table().Get(target); ok {
+			return nil
+		}
+
+		closest := d.srv.Table().Closest(target, kad.BucketSize)
+		var toQuery []kad.Record
+		for _, r := range closest {
+			if !queried[r.DiscAddr] {
+				toQuery = append(toQuery, r)
+			}
+			if len(toQuery) == lookupAlpha {
+				break
+			}
+		}
+		if len(toQuery) == 0 {
+			return ErrUnknownRemote
+		}
+
+		var wg sync.WaitGroup
+		for _, r := range toQuery {
+			queried[r.DiscAddr] = true
+			wg.Add(1)
+			go func(r kad.Record) {
+				defer wg.Done()
+				d.queryOne(ctx, r, target)
+			}(r)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrUnknownRemote, ctx.Err())
+		default:
+		}
+	}
+}
+
+func (d *DiscoveryPubKeyTable) queryOne(ctx context.Context, r kad.Record, target cipher.PubKey) {
+	addr, err := net.ResolveUDPAddr("udp", r.DiscAddr)
+	if err != nil {
+		return
+	}
+
+	qCtx, cancel := context.WithTimeout(ctx, lookupRPCTimeout)
+	defer cancel()
+
+	records, err := d.srv.FindNode(qCtx, addr, target)
+	if err != nil {
+		return
+	}
+	for _, rec := range records {
+		if err := rec.Verify(); err != nil {
+			d.logf("dropping unverifiable record for %v returned by %v", rec.PK, addr)
+			continue
+		}
+		d.srv.Table().Update(rec)
+	}
+}
+
+func (d *DiscoveryPubKeyTable) bootstrapLoop() {
+	d.pingBootnodes()
+
+	ticker := time.NewTicker(bootstrapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.closeCh:
+			return
+		case <-ticker.C:
+			if d.srv.Table().Count() == 0 {
+				d.pingBootnodes()
+			}
+		}
+	}
+}
+
+func (d *DiscoveryPubKeyTable) pingBootnodes() {
+	for _, addr := range d.bootnodes {
+		ctx, cancel := context.WithTimeout(context.Background(), lookupRPCTimeout)
+		err := d.srv.Ping(ctx, addr)
+		cancel()
+		if err != nil {
+			d.logf("failed to ping bootnode %v: %v", addr, err)
+			continue
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), lookupRPCTimeout)
+		if err := d.lookupVia(ctx, addr, d.localPK); err != nil {
+			d.logf("failed to seed table from bootnode %v: %v", addr, err)
+		}
+		cancel()
+	}
+}
+
+func (d *DiscoveryPubKeyTable) lookupVia(ctx context.Context, addr *net.UDPAddr, target cipher.PubKey) error {
+	records, err := d.srv.FindNode(ctx, addr, target)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := rec.Verify(); err != nil {
+			continue
+		}
+		d.srv.Table().Update(rec)
+	}
+	return nil
+}
+
+// republishLoop periodically signs a fresh local record with a higher Seq
+// and sends a FIND_NODE for the local key to up to kad.BucketSize of the
+// closest known peers, then sweeps its own table for stale peers. The
+// signed record itself is not sent to any peer (and Table.Update ignores
+// the local key, so it isn't kept locally either), and bootnodes are not
+// re-pinged here.
+func (d *DiscoveryPubKeyTable) republishLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.closeCh:
+			return
+		case <-ticker.C:
+			d.republish()
+			d.srv.Table().EvictStale()
+		}
+	}
+}
+
+func (d *DiscoveryPubKeyTable) republish() {
+	seq := atomic.AddUint64(&d.seq, 1)
+	record, err := kad.NewRecord(d.localPK, d.localSK, d.localTCPAddr, d.localDiscAddr, seq)
+	if err != nil {
+		d.logf("failed to sign local record: %v", err)
+		return
+	}
+	d.srv.Table().Update(record)
+
+	peers := d.srv.Table().Closest(d.localPK, kad.BucketSize)
+	for _, p := range peers {
+		addr, err := net.ResolveUDPAddr("udp", p.DiscAddr)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), lookupRPCTimeout)
+		_, _ = d.srv.FindNode(ctx, addr, d.localPK) // nolint: errcheck
+		cancel()
+	}
+}
+
+func (d *DiscoveryPubKeyTable) logf(format string, args ...interface{}) {
+	if d.log != nil {
+		d.log.Infof(format, args...)
+	}
+}
+
+// fallbackPKTable tries each table in the given order, falling through to
+// the next whenever one reports a result unknown.
+type fallbackPKTable struct {
+	tables []PubKeyTable
+}
+
+// FallbackPubKeyTable composes tables so lookups check each in order and
+// stop at the first hit; this lets callers stack a static FilePubKeyTable
+// (seed peers) in front of a DiscoveryPubKeyTable.
+func FallbackPubKeyTable(tables ...PubKeyTable) PubKeyTable {
+	return &fallbackPKTable{tables}
+}
+
+func (f *fallbackPKTable) RemoteAddr(remotePK cipher.PubKey) string {
+	for _, t := range f.tables {
+		if addr := t.RemoteAddr(remotePK); addr != "" {
+			return addr
+		}
+	}
+	return ""
+}
+
+func (f *fallbackPKTable) RemotePK(address string) cipher.PubKey {
+	for _, t := range f.tables {
+		if pk := t.RemotePK(address); !pk.Null() {
+			return pk
+		}
+	}
+	return cipher.PubKey{}
+}
+
+func (f *fallbackPKTable) Count() int {
+	total := 0
+	for _, t := range f.tables {
+		total += t.Count()
+	}
+	return total
+}
+
+func (f *fallbackPKTable) Resolve(ctx context.Context, remotePK cipher.PubKey) (string, error) {
+	var lastErr error = ErrUnknownRemote
+	for _, t := range f.tables {
+		addr, err := t.Resolve(ctx, remotePK)
+		if err == nil {
+			return addr, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
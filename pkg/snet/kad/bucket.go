@@ -0,0 +1,218 @@
+package kad
+
+import (
+	"bytes"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skycoin/dmsg/cipher"
+)
+
+const (
+	// BucketSize is the maximum number of entries held in a single
+	// k-bucket (k, in the Kademlia paper).
+	BucketSize = 20
+
+	// staleAfter is how long an entry may go unrefreshed before
+	// EvictStale drops it.
+	staleAfter = 15 * time.Minute
+)
+
+type entry struct {
+	Record
+	lastSeen time.Time
+}
+
+// Table is a Kademlia-style k-bucket routing table keyed by XOR distance
+// to a local public key. It is safe for concurrent use.
+type Table struct {
+	mu      sync.Mutex
+	localPK cipher.PubKey
+	buckets []*bucket // buckets[i] holds peers whose distance has its highest set bit at position i
+	byAddr  map[string]cipher.PubKey
+}
+
+type bucket struct {
+	entries []entry // ordered oldest (front) to most recently seen (back)
+}
+
+// NewTable returns an empty routing table centered on localPK.
+func NewTable(localPK cipher.PubKey) *Table {
+	return &Table{
+		localPK: localPK,
+		buckets: make([]*bucket, 8*len(localPK)),
+		byAddr:  make(map[string]cipher.PubKey),
+	}
+}
+
+// distance returns the XOR distance between a and b.
+func distance(a, b cipher.PubKey) []byte {
+	d := make([]byte, len(a))
+	for i := range a {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// bucketIndex returns the index of the bucket that should hold a peer at
+// the given XOR distance from the local key: the position of its highest
+// set bit, counting from the most significant bit of the key.
+func bucketIndex(d []byte) int {
+	for i, b := range d {
+		if b == 0 {
+			continue
+		}
+		return i*8 + bits.LeadingZeros8(b)
+	}
+	return len(d)*8 - 1 // d is all zero (pk == localPK); shouldn't normally happen
+}
+
+// Update inserts or refreshes r in the table. A record already known for
+// r.PK is only replaced if r carries a strictly newer Seq. If the target
+// bucket is full, the table evicts its least-recently-seen stale entry to
+// make room; otherwise the new record is dropped (the existing Kademlia
+// peers are assumed to still be good until proven otherwise).
+func (t *Table) Update(r Record) {
+	if r.PK == t.localPK {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.buckets[bucketIndex(distance(t.localPK, r.PK))]
+	if b == nil {
+		b = &bucket{}
+		t.buckets[bucketIndex(distance(t.localPK, r.PK))] = b
+	}
+
+	for i, e := range b.entries {
+		if e.PK == r.PK {
+			if r.Seq <= e.Seq {
+				e.lastSeen = time.Now()
+				b.entries = append(append(b.entries[:i], b.entries[i+1:]...), e)
+				return
+			}
+			delete(t.byAddr, e.TCPAddr)
+			b.entries = append(b.entries[:i], b.entries[i+1:]...)
+			break
+		}
+	}
+
+	if len(b.entries) >= BucketSize {
+		t.evictStaleFromLocked(b)
+	}
+	if len(b.entries) >= BucketSize {
+		return // bucket full of fresh peers; drop the new record
+	}
+
+	b.entries = append(b.entries, entry{Record: r, lastSeen: time.Now()})
+	t.byAddr[r.TCPAddr] = r.PK
+}
+
+// evictStaleFromLocked drops the oldest entry in b if it hasn't been
+// refreshed within staleAfter. Caller must hold t.mu.
+func (t *Table) evictStaleFromLocked(b *bucket) {
+	if len(b.entries) == 0 {
+		return
+	}
+	oldest := b.entries[0]
+	if time.Since(oldest.lastSeen) < staleAfter {
+		return
+	}
+	delete(t.byAddr, oldest.TCPAddr)
+	b.entries = b.entries[1:]
+}
+
+// EvictStale drops every entry across the table that hasn't been
+// refreshed within staleAfter.
+func (t *Table) EvictStale() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, b := range t.buckets {
+		if b == nil {
+			continue
+		}
+		fresh := b.entries[:0]
+		for _, e := range b.entries {
+			if time.Since(e.lastSeen) >= staleAfter {
+				delete(t.byAddr, e.TCPAddr)
+				continue
+			}
+			fresh = append(fresh, e)
+		}
+		b.entries = fresh
+	}
+}
+
+// Get returns the record known for pk, if any.
+func (t *Table) Get(pk cipher.PubKey) (Record, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.buckets[bucketIndex(distance(t.localPK, pk))]
+	if b == nil {
+		return Record{}, false
+	}
+	for _, e := range b.entries {
+		if e.PK == pk {
+			return e.Record, true
+		}
+	}
+	return Record{}, false
+}
+
+// ByAddr returns the public key last known to be announcing addr.
+func (t *Table) ByAddr(addr string) (cipher.PubKey, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pk, ok := t.byAddr[addr]
+	return pk, ok
+}
+
+// Closest returns up to n known records ordered by ascending XOR distance
+// to target.
+func (t *Table) Closest(target cipher.PubKey, n int) []Record {
+	t.mu.Lock()
+	all := make([]Record, 0)
+	for _, b := range t.buckets {
+		if b == nil {
+			continue
+		}
+		for _, e := range b.entries {
+			all = append(all, e.Record)
+		}
+	}
+	t.mu.Unlock()
+
+	sortByDistance(all, target)
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// Count returns the total number of records known across all buckets.
+func (t *Table) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, b := range t.buckets {
+		if b != nil {
+			n += len(b.entries)
+		}
+	}
+	return n
+}
+
+func sortByDistance(records []Record, target cipher.PubKey) {
+	less := func(i, j int) bool {
+		return bytes.Compare(distance(records[i].PK, target), distance(records[j].PK, target)) < 0
+	}
+	sort.Slice(records, less)
+}
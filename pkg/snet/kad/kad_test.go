@@ -0,0 +1,98 @@
+package kad
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skycoin/dmsg/cipher"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordVerify(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	r, err := NewRecord(pk, sk, "127.0.0.1:7777", "127.0.0.1:7778", 1)
+	require.NoError(t, err)
+	require.NoError(t, r.Verify())
+
+	r.TCPAddr = "10.0.0.1:7777"
+	require.Error(t, r.Verify())
+}
+
+// TestRecordVerifyRejectsFieldBoundaryShift guards against a record
+// signed over (TCPAddr, DiscAddr) being re-carved at a different
+// TCPAddr/DiscAddr split -- e.g. "10.0.0.1:8080"/"10.0.0.1:9090"
+// reinterpreted as "10.0.0.1:808"/"010.0.0.1:9090" -- which would still
+// verify if signingPayload concatenated the two fields without a length
+// prefix or delimiter.
+func TestRecordVerifyRejectsFieldBoundaryShift(t *testing.T) {
+	pk, sk := cipher.GenerateKeyPair()
+	r, err := NewRecord(pk, sk, "10.0.0.1:8080", "10.0.0.1:9090", 1)
+	require.NoError(t, err)
+	require.NoError(t, r.Verify())
+
+	shifted := r
+	shifted.TCPAddr = "10.0.0.1:808"
+	shifted.DiscAddr = "010.0.0.1:9090"
+	require.Error(t, shifted.Verify())
+}
+
+func TestTableClosestAndGet(t *testing.T) {
+	localPK, _ := cipher.GenerateKeyPair()
+	table := NewTable(localPK)
+
+	var target cipher.PubKey
+	for i := 0; i < 5; i++ {
+		pk, sk := cipher.GenerateKeyPair()
+		if i == 2 {
+			target = pk
+		}
+		r, err := NewRecord(pk, sk, "127.0.0.1:0", "127.0.0.1:0", 1)
+		require.NoError(t, err)
+		table.Update(r)
+	}
+	require.Equal(t, 5, table.Count())
+
+	closest := table.Closest(target, 1)
+	require.Len(t, closest, 1)
+	require.Equal(t, target, closest[0].PK)
+
+	rec, ok := table.Get(target)
+	require.True(t, ok)
+	require.Equal(t, target, rec.PK)
+}
+
+func newLoopbackServer(t *testing.T, localPK cipher.PubKey) (*Server, *net.UDPAddr) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	srv := NewServer(localPK, cipher.SecKey{}, conn, NewTable(localPK), nil)
+	go srv.Serve() // nolint: errcheck
+	return srv, conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestServerFindNode(t *testing.T) {
+	aPK, _ := cipher.GenerateKeyPair()
+	bPK, _ := cipher.GenerateKeyPair()
+	targetPK, targetSK := cipher.GenerateKeyPair()
+
+	a, _ := newLoopbackServer(t, aPK)
+	defer a.Close() // nolint: errcheck
+	b, bAddr := newLoopbackServer(t, bPK)
+	defer b.Close() // nolint: errcheck
+
+	targetRecord, err := NewRecord(targetPK, targetSK, "127.0.0.1:9999", "127.0.0.1:9998", 1)
+	require.NoError(t, err)
+	b.Table().Update(targetRecord)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, a.Ping(ctx, bAddr))
+
+	records, err := a.FindNode(ctx, bAddr, targetPK)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, targetPK, records[0].PK)
+	require.Equal(t, "127.0.0.1:9999", records[0].TCPAddr)
+}
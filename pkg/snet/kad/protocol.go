@@ -0,0 +1,49 @@
+package kad
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/skycoin/dmsg/cipher"
+)
+
+// ErrMalformedMessage is returned when a UDP datagram cannot be decoded
+// into a known message.
+var ErrMalformedMessage = errors.New("kad: malformed message")
+
+type msgType byte
+
+const (
+	msgPing msgType = iota + 1
+	msgPong
+	msgFindNode
+	msgFoundNodes
+)
+
+type pingMsg struct{}
+
+type pongMsg struct{}
+
+type findNodeMsg struct {
+	Target cipher.PubKey
+}
+
+type foundNodesMsg struct {
+	Records []Record
+}
+
+// encode prefixes the JSON-encoded body with a one-byte message type.
+func encode(t msgType, v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(t)}, body...), nil
+}
+
+func decode(b []byte) (msgType, []byte, error) {
+	if len(b) < 1 {
+		return 0, nil, ErrMalformedMessage
+	}
+	return msgType(b[0]), b[1:], nil
+}
@@ -0,0 +1,213 @@
+package kad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/skycoin/dmsg/cipher"
+	"github.com/skycoin/skycoin/src/util/logging"
+)
+
+const maxDatagramSize = 8192
+
+// Server is a single Kademlia-style discovery node: it listens on UDP,
+// answers FIND_NODE queries against its local Table, and can issue PING
+// and FIND_NODE requests against other peers.
+type Server struct {
+	conn    *net.UDPConn
+	table   *Table
+	localPK cipher.PubKey
+	localSK cipher.SecKey
+	log     *logging.Logger
+
+	mu          sync.Mutex
+	pendingPong map[string]chan struct{}
+	pendingFind map[string]chan foundNodesMsg
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+// NewServer wraps conn into a discovery Server backed by table. Outgoing
+// pings and lookups are unsigned; only the records it serves are signed,
+// each by the node that announced it. localSK is stored but not used by
+// the Server itself, and since Table.Update ignores records for the local
+// key, FIND_NODE answers never include the local node's own record.
+func NewServer(localPK cipher.PubKey, localSK cipher.SecKey, conn *net.UDPConn, table *Table, log *logging.Logger) *Server {
+	return &Server{
+		conn:        conn,
+		table:       table,
+		localPK:     localPK,
+		localSK:     localSK,
+		log:         log,
+		pendingPong: make(map[string]chan struct{}),
+		pendingFind: make(map[string]chan foundNodesMsg),
+		closeCh:     make(chan struct{}),
+	}
+}
+
+// Serve reads datagrams off the socket until the server is closed or the
+// socket errors out. It should be run in its own goroutine.
+func (s *Server) Serve() error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, raddr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.closeCh:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+		go s.handle(raddr, msg)
+	}
+}
+
+// Close shuts down the underlying socket, causing Serve to return.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() { close(s.closeCh) })
+	return s.conn.Close()
+}
+
+func (s *Server) handle(raddr *net.UDPAddr, data []byte) {
+	t, body, err := decode(data)
+	if err != nil {
+		s.logf("dropping malformed datagram from %v: %v", raddr, err)
+		return
+	}
+
+	switch t {
+	case msgPing:
+		if err := s.send(raddr, msgPong, pongMsg{}); err != nil {
+			s.logf("failed to pong %v: %v", raddr, err)
+		}
+
+	case msgPong:
+		s.mu.Lock()
+		ch, ok := s.pendingPong[raddr.String()]
+		s.mu.Unlock()
+		if ok {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+
+	case msgFindNode:
+		var m findNodeMsg
+		if err := json.Unmarshal(body, &m); err != nil {
+			s.logf("dropping malformed FIND_NODE from %v: %v", raddr, err)
+			return
+		}
+		records := s.table.Closest(m.Target, BucketSize)
+		if err := s.send(raddr, msgFoundNodes, foundNodesMsg{Records: records}); err != nil {
+			s.logf("failed to answer FIND_NODE from %v: %v", raddr, err)
+		}
+
+	case msgFoundNodes:
+		var m foundNodesMsg
+		if err := json.Unmarshal(body, &m); err != nil {
+			s.logf("dropping malformed FOUND_NODES from %v: %v", raddr, err)
+			return
+		}
+		for _, r := range m.Records {
+			if err := r.Verify(); err != nil {
+				s.logf("dropping record for %v from %v: bad signature", r.PK, raddr)
+				continue
+			}
+			s.table.Update(r)
+		}
+
+		s.mu.Lock()
+		ch, ok := s.pendingFind[raddr.String()]
+		s.mu.Unlock()
+		if ok {
+			select {
+			case ch <- m:
+			default:
+			}
+		}
+
+	default:
+		s.logf("dropping datagram of unknown type %d from %v", t, raddr)
+	}
+}
+
+func (s *Server) send(addr *net.UDPAddr, t msgType, v interface{}) error {
+	data, err := encode(t, v)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.WriteToUDP(data, addr)
+	return err
+}
+
+// Ping blocks until addr answers with a PONG or ctx is done.
+func (s *Server) Ping(ctx context.Context, addr *net.UDPAddr) error {
+	ch := make(chan struct{}, 1)
+	key := addr.String()
+
+	s.mu.Lock()
+	s.pendingPong[key] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pendingPong, key)
+		s.mu.Unlock()
+	}()
+
+	if err := s.send(addr, msgPing, pingMsg{}); err != nil {
+		return err
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("kad: ping %v: %w", addr, ctx.Err())
+	}
+}
+
+// FindNode asks addr for the records it knows closest to target.
+func (s *Server) FindNode(ctx context.Context, addr *net.UDPAddr, target cipher.PubKey) ([]Record, error) {
+	ch := make(chan foundNodesMsg, 1)
+	key := addr.String()
+
+	s.mu.Lock()
+	s.pendingFind[key] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pendingFind, key)
+		s.mu.Unlock()
+	}()
+
+	if err := s.send(addr, msgFindNode, findNodeMsg{Target: target}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case m := <-ch:
+		return m.Records, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("kad: find_node %v: %w", addr, ctx.Err())
+	}
+}
+
+// Table returns the server's routing table.
+func (s *Server) Table() *Table {
+	return s.table
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.log != nil {
+		s.log.Infof(format, args...)
+	}
+}
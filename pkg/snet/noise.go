@@ -0,0 +1,487 @@
+//go:build !no_ci
+// +build !no_ci
+
+package snet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/skycoin/dmsg/cipher"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrAuthFailed is returned when the remote's static key does not match
+// the PubKeyTable entry recorded for it.
+var ErrAuthFailed = errors.New("remote static key does not match expected public key")
+
+const (
+	noiseProtocolName = "Noise_XX_25519_ChaChaPoly_SHA256"
+	noiseMaxMsgLen    = 65535
+	noiseLenFieldLen  = 2
+	noiseTagLen       = 16
+)
+
+// noiseHandshake drives one side of an XX-pattern Noise handshake, using an
+// ephemeral X25519 keypair for the Diffie-Hellman exchange and the node's
+// Ed25519 identity keypair to authenticate the static key it presents.
+type noiseHandshake struct {
+	initiator bool
+
+	localID  cipher.PubKey
+	localSK  cipher.SecKey
+	remoteID cipher.PubKey // expected remote identity, may be null for Accept until verified
+
+	localEphemeral  [32]byte
+	localEphemeralP [32]byte
+	localStatic     [32]byte
+	localStaticP    [32]byte
+
+	remoteEphemeral [32]byte
+	remoteStatic    [32]byte
+
+	h  [32]byte // handshake hash
+	ck [32]byte // chaining key
+
+	hasKey bool
+	k      [32]byte
+}
+
+func newNoiseHandshake(initiator bool, localID cipher.PubKey, localSK cipher.SecKey, remoteID cipher.PubKey) (*noiseHandshake, error) {
+	hs := &noiseHandshake{initiator: initiator, localID: localID, localSK: localSK, remoteID: remoteID}
+
+	if _, err := io.ReadFull(rand.Reader, hs.localEphemeral[:]); err != nil {
+		return nil, err
+	}
+	curve25519.ScalarBaseMult(&hs.localEphemeralP, &hs.localEphemeral)
+
+	if _, err := io.ReadFull(rand.Reader, hs.localStatic[:]); err != nil {
+		return nil, err
+	}
+	curve25519.ScalarBaseMult(&hs.localStaticP, &hs.localStatic)
+
+	hs.ck = sha256.Sum256([]byte(noiseProtocolName))
+	hs.h = hs.ck
+	return hs, nil
+}
+
+func (hs *noiseHandshake) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(hs.h[:]) // nolint: errcheck
+	h.Write(data)    // nolint: errcheck
+	copy(hs.h[:], h.Sum(nil))
+}
+
+func (hs *noiseHandshake) mixKey(input []byte) {
+	out := hkdf.New(sha256.New, input, hs.ck[:], nil)
+	var ck, k [32]byte
+	io.ReadFull(out, ck[:]) // nolint: errcheck
+	io.ReadFull(out, k[:])  // nolint: errcheck
+	hs.ck = ck
+	hs.k = k
+	hs.hasKey = true
+}
+
+func dh(priv, pub [32]byte) ([]byte, error) {
+	out, err := curve25519.X25519(priv[:], pub[:])
+	if err != nil {
+		return nil, fmt.Errorf("noise: dh failed: %w", err)
+	}
+	return out, nil
+}
+
+// encryptAndHash seals data under the current key (if any) and mixes the
+// ciphertext into the handshake hash, per the Noise spec.
+func (hs *noiseHandshake) encryptAndHash(plaintext []byte) ([]byte, error) {
+	if !hs.hasKey {
+		hs.mixHash(plaintext)
+		return plaintext, nil
+	}
+	aead, err := chacha20poly1305.New(hs.k[:])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [12]byte
+	ct := aead.Seal(nil, nonce[:], plaintext, hs.h[:])
+	hs.mixHash(ct)
+	return ct, nil
+}
+
+func (hs *noiseHandshake) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	if !hs.hasKey {
+		hs.mixHash(ciphertext)
+		return ciphertext, nil
+	}
+	aead, err := chacha20poly1305.New(hs.k[:])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [12]byte
+	pt, err := aead.Open(nil, nonce[:], ciphertext, hs.h[:])
+	if err != nil {
+		return nil, fmt.Errorf("noise: failed to decrypt handshake message: %w", err)
+	}
+	hs.mixHash(ciphertext)
+	return pt, nil
+}
+
+// identityPayload is the authenticated payload sent alongside the static
+// key: it binds the Noise static key to the sender's long-term Ed25519
+// identity so the receiver can verify it against PubKeyTable.
+type identityPayload struct {
+	id  cipher.PubKey
+	sig cipher.Sig
+}
+
+func signStatic(id cipher.PubKey, sk cipher.SecKey, static [32]byte) (identityPayload, error) {
+	sig, err := cipher.SignPayload(static[:], sk)
+	if err != nil {
+		return identityPayload{}, fmt.Errorf("failed to sign noise static key: %w", err)
+	}
+	return identityPayload{id: id, sig: sig}, nil
+}
+
+func (p identityPayload) marshal() []byte {
+	out := make([]byte, 0, 33+65)
+	out = append(out, p.id[:]...)
+	out = append(out, p.sig[:]...)
+	return out
+}
+
+func unmarshalIdentityPayload(b []byte) (identityPayload, error) {
+	var p identityPayload
+	if len(b) != len(p.id)+len(p.sig) {
+		return p, errors.New("noise: malformed identity payload")
+	}
+	copy(p.id[:], b[:len(p.id)])
+	copy(p.sig[:], b[len(p.id):])
+	return p, nil
+}
+
+func verifyStatic(p identityPayload, expected cipher.PubKey, static [32]byte) error {
+	if !expected.Null() && p.id != expected {
+		return ErrAuthFailed
+	}
+	if err := cipher.VerifyPubKeySignedPayload(p.id, p.sig, static[:]); err != nil {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+func writeFrame(w io.Writer, p []byte) error {
+	var lenBuf [noiseLenFieldLen]byte
+	binary.BigEndian.PutUint16(lenBuf[:], uint16(len(p)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var lenBuf [noiseLenFieldLen]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint16(lenBuf[:])
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// handshakeResult holds the two directional AEAD keys derived once the XX
+// handshake completes, along with the verified remote identity.
+type handshakeResult struct {
+	remoteID cipher.PubKey
+	sendKey  [32]byte
+	recvKey  [32]byte
+}
+
+// doHandshake runs the full Noise_XX exchange over conn and returns the
+// derived send/recv keys plus the authenticated remote identity. It fails
+// closed: on any error the caller is expected to close the connection.
+func doHandshake(conn net.Conn, hs *noiseHandshake) (*handshakeResult, error) {
+	if hs.initiator {
+		return hs.runInitiator(conn)
+	}
+	return hs.runResponder(conn)
+}
+
+func (hs *noiseHandshake) runInitiator(conn net.Conn) (*handshakeResult, error) {
+	// -> e
+	e1, err := hs.encryptAndHash(hs.localEphemeralP[:])
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, e1); err != nil {
+		return nil, err
+	}
+
+	// <- e, ee, s, es
+	msg2, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg2) < 32+32+noiseTagLen {
+		return nil, errors.New("noise: short message 2")
+	}
+	copy(hs.remoteEphemeral[:], msg2[:32])
+	if _, err := hs.decryptAndHash(msg2[:32]); err != nil {
+		return nil, err
+	}
+
+	dhEE, err := dh(hs.localEphemeral, hs.remoteEphemeral)
+	if err != nil {
+		return nil, err
+	}
+	hs.mixKey(dhEE)
+
+	rest := msg2[32:]
+	sTag := rest[:32+noiseTagLen]
+	sPlain, err := hs.decryptAndHash(sTag)
+	if err != nil {
+		return nil, err
+	}
+	copy(hs.remoteStatic[:], sPlain)
+
+	dhES, err := dh(hs.localEphemeral, hs.remoteStatic)
+	if err != nil {
+		return nil, err
+	}
+	hs.mixKey(dhES)
+
+	idCipher := rest[32+noiseTagLen:]
+	idPlain, err := hs.decryptAndHash(idCipher)
+	if err != nil {
+		return nil, err
+	}
+	remoteIdentity, err := unmarshalIdentityPayload(idPlain)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyStatic(remoteIdentity, hs.remoteID, hs.remoteStatic); err != nil {
+		return nil, err
+	}
+
+	// -> s, se
+	sCipher, err := hs.encryptAndHash(hs.localStaticP[:])
+	if err != nil {
+		return nil, err
+	}
+	dhSE, err := dh(hs.localStatic, hs.remoteEphemeral)
+	if err != nil {
+		return nil, err
+	}
+	hs.mixKey(dhSE)
+
+	localIdentity, err := signStatic(hs.localID, hs.localSK, hs.localStaticP)
+	if err != nil {
+		return nil, err
+	}
+	idCipherOut, err := hs.encryptAndHash(localIdentity.marshal())
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, append(sCipher, idCipherOut...)); err != nil {
+		return nil, err
+	}
+
+	return hs.split(remoteIdentity.id)
+}
+
+func (hs *noiseHandshake) runResponder(conn net.Conn) (*handshakeResult, error) {
+	// -> e
+	msg1, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := hs.decryptAndHash(msg1); err != nil {
+		return nil, err
+	}
+	copy(hs.remoteEphemeral[:], msg1)
+
+	// <- e, ee, s, es
+	e2, err := hs.encryptAndHash(hs.localEphemeralP[:])
+	if err != nil {
+		return nil, err
+	}
+	dhEE, err := dh(hs.localEphemeral, hs.remoteEphemeral)
+	if err != nil {
+		return nil, err
+	}
+	hs.mixKey(dhEE)
+
+	sCipher, err := hs.encryptAndHash(hs.localStaticP[:])
+	if err != nil {
+		return nil, err
+	}
+	dhES, err := dh(hs.localStatic, hs.remoteEphemeral)
+	if err != nil {
+		return nil, err
+	}
+	hs.mixKey(dhES)
+
+	localIdentity, err := signStatic(hs.localID, hs.localSK, hs.localStaticP)
+	if err != nil {
+		return nil, err
+	}
+	idCipher, err := hs.encryptAndHash(localIdentity.marshal())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFrame(conn, append(e2, append(sCipher, idCipher...)...)); err != nil {
+		return nil, err
+	}
+
+	// -> s, se
+	msg3, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg3) < 32+noiseTagLen {
+		return nil, errors.New("noise: short message 3")
+	}
+	sTag := msg3[:32+noiseTagLen]
+	sPlain, err := hs.decryptAndHash(sTag)
+	if err != nil {
+		return nil, err
+	}
+	copy(hs.remoteStatic[:], sPlain)
+
+	dhSE2, err := dh(hs.localEphemeral, hs.remoteStatic)
+	if err != nil {
+		return nil, err
+	}
+	hs.mixKey(dhSE2)
+
+	idCipherIn := msg3[32+noiseTagLen:]
+	idPlain, err := hs.decryptAndHash(idCipherIn)
+	if err != nil {
+		return nil, err
+	}
+	remoteIdentity, err := unmarshalIdentityPayload(idPlain)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyStatic(remoteIdentity, hs.remoteID, hs.remoteStatic); err != nil {
+		return nil, err
+	}
+
+	return hs.split(remoteIdentity.id)
+}
+
+// split derives the two directional transport keys from the final
+// chaining key, one for each direction so both peers use independent
+// nonce counters.
+func (hs *noiseHandshake) split(remoteID cipher.PubKey) (*handshakeResult, error) {
+	out := hkdf.New(sha256.New, nil, hs.ck[:], nil)
+	var k1, k2 [32]byte
+	if _, err := io.ReadFull(out, k1[:]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(out, k2[:]); err != nil {
+		return nil, err
+	}
+
+	res := &handshakeResult{remoteID: remoteID}
+	if hs.initiator {
+		res.sendKey, res.recvKey = k1, k2
+	} else {
+		res.sendKey, res.recvKey = k2, k1
+	}
+	return res, nil
+}
+
+// aeadConn wraps a net.Conn, framing every Write/Read as a length-prefixed
+// ChaCha20-Poly1305 sealed record. Each direction uses its own key and an
+// independent 64-bit counter nonce, so replays or reordering across
+// directions cannot reuse a nonce.
+type aeadConn struct {
+	net.Conn
+
+	sendAEAD  cipherAEAD
+	recvAEAD  cipherAEAD
+	sendNonce uint64
+	recvNonce uint64
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+	readBuf []byte
+}
+
+type cipherAEAD interface {
+	Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+func newAEADConn(conn net.Conn, res *handshakeResult) (*aeadConn, error) {
+	sendAEAD, err := chacha20poly1305.New(res.sendKey[:])
+	if err != nil {
+		return nil, err
+	}
+	recvAEAD, err := chacha20poly1305.New(res.recvKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return &aeadConn{Conn: conn, sendAEAD: sendAEAD, recvAEAD: recvAEAD}, nil
+}
+
+func nonceBytes(counter uint64) []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+	return nonce[:]
+}
+
+func (c *aeadConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	total := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > noiseMaxMsgLen-noiseTagLen {
+			chunk = chunk[:noiseMaxMsgLen-noiseTagLen]
+		}
+		ct := c.sendAEAD.Seal(nil, nonceBytes(c.sendNonce), chunk, nil)
+		c.sendNonce++
+		if err := writeFrame(c.Conn, ct); err != nil {
+			return total, err
+		}
+		total += len(chunk)
+		p = p[len(chunk):]
+	}
+	return total, nil
+}
+
+func (c *aeadConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(c.readBuf) == 0 {
+		ct, err := readFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		pt, err := c.recvAEAD.Open(nil, nonceBytes(c.recvNonce), ct, nil)
+		if err != nil {
+			return 0, fmt.Errorf("snet: failed to decrypt frame: %w", err)
+		}
+		c.recvNonce++
+		c.readBuf = pt
+	}
+
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
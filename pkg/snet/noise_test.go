@@ -0,0 +1,173 @@
+//go:build !no_ci
+// +build !no_ci
+
+package snet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/skycoin/dmsg/cipher"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoiseHandshakeAndFraming(t *testing.T) {
+	initPK, initSK := cipher.GenerateKeyPair()
+	respPK, respSK := cipher.GenerateKeyPair()
+
+	initConn, respConn := net.Pipe()
+
+	type result struct {
+		res *handshakeResult
+		err error
+	}
+	initCh := make(chan result, 1)
+	respCh := make(chan result, 1)
+
+	go func() {
+		hs, err := newNoiseHandshake(true, initPK, initSK, respPK)
+		if err != nil {
+			initCh <- result{nil, err}
+			return
+		}
+		res, err := doHandshake(initConn, hs)
+		initCh <- result{res, err}
+	}()
+	go func() {
+		hs, err := newNoiseHandshake(false, respPK, respSK, initPK)
+		if err != nil {
+			respCh <- result{nil, err}
+			return
+		}
+		res, err := doHandshake(respConn, hs)
+		respCh <- result{res, err}
+	}()
+
+	initRes := <-initCh
+	respRes := <-respCh
+	require.NoError(t, initRes.err)
+	require.NoError(t, respRes.err)
+	require.Equal(t, respPK, initRes.res.remoteID)
+	require.Equal(t, initPK, respRes.res.remoteID)
+	require.Equal(t, initRes.res.sendKey, respRes.res.recvKey)
+	require.Equal(t, initRes.res.recvKey, respRes.res.sendKey)
+
+	initAEAD, err := newAEADConn(initConn, initRes.res)
+	require.NoError(t, err)
+	respAEAD, err := newAEADConn(respConn, respRes.res)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, len(msg))
+		n, err := respAEAD.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, msg, buf[:n])
+	}()
+
+	_, err = initAEAD.Write(msg)
+	require.NoError(t, err)
+	<-done
+}
+
+var msg = []byte("the quick brown fox jumps over the lazy dog")
+
+func TestNoiseHandshakeRejectsWrongIdentity(t *testing.T) {
+	initPK, initSK := cipher.GenerateKeyPair()
+	_, respSK := cipher.GenerateKeyPair()
+	otherPK, _ := cipher.GenerateKeyPair()
+
+	initConn, respConn := net.Pipe()
+
+	// A real caller closes the connection as soon as the handshake fails
+	// (see TCPFactory.Accept/Dial); mirror that here so a rejection on one
+	// side unblocks the other side's pending read instead of deadlocking.
+	respCh := make(chan error, 1)
+	go func() {
+		defer respConn.Close() // nolint: errcheck
+		hs, err := newNoiseHandshake(false, otherPK, respSK, initPK)
+		if err != nil {
+			respCh <- err
+			return
+		}
+		_, err = doHandshake(respConn, hs)
+		respCh <- err
+	}()
+
+	hs, err := newNoiseHandshake(true, initPK, initSK, otherPK)
+	require.NoError(t, err)
+	_, initErr := doHandshake(initConn, hs)
+	initConn.Close() // nolint: errcheck
+
+	require.Error(t, initErr)
+	require.Error(t, <-respCh)
+}
+
+func TestNoiseInitiatorRejectsShortMessage2(t *testing.T) {
+	initPK, initSK := cipher.GenerateKeyPair()
+	respPK, _ := cipher.GenerateKeyPair()
+
+	initConn, respConn := net.Pipe()
+
+	initCh := make(chan error, 1)
+	go func() {
+		defer initConn.Close() // nolint: errcheck
+		hs, err := newNoiseHandshake(true, initPK, initSK, respPK)
+		if err != nil {
+			initCh <- err
+			return
+		}
+		_, err = doHandshake(initConn, hs)
+		initCh <- err
+	}()
+
+	_, err := readFrame(respConn) // msg1, discarded
+	require.NoError(t, err)
+
+	// A well-formed ephemeral key (32 bytes) but missing the static-key
+	// ciphertext and tag that must follow it: a naive "len < 32" bounds
+	// check would let this slip through and panic on the later
+	// rest[:32+noiseTagLen] slice.
+	require.NoError(t, writeFrame(respConn, make([]byte, 32)))
+	respConn.Close() // nolint: errcheck
+
+	require.Error(t, <-initCh)
+}
+
+func TestNoiseResponderRejectsShortMessage3(t *testing.T) {
+	initPK, _ := cipher.GenerateKeyPair()
+	respPK, respSK := cipher.GenerateKeyPair()
+
+	initConn, respConn := net.Pipe()
+
+	respCh := make(chan error, 1)
+	go func() {
+		defer respConn.Close() // nolint: errcheck
+		hs, err := newNoiseHandshake(false, respPK, respSK, initPK)
+		if err != nil {
+			respCh <- err
+			return
+		}
+		_, err = doHandshake(respConn, hs)
+		respCh <- err
+	}()
+
+	// Play just enough of the initiator side to reach message 3 (localSK
+	// is never touched by this much of the handshake, so a zero key is
+	// fine), then send a short garbage frame instead of a well-formed one.
+	ihs, err := newNoiseHandshake(true, initPK, cipher.SecKey{}, respPK)
+	require.NoError(t, err)
+
+	e1, err := ihs.encryptAndHash(ihs.localEphemeralP[:])
+	require.NoError(t, err)
+	require.NoError(t, writeFrame(initConn, e1))
+
+	_, err = readFrame(initConn) // msg2, discarded
+	require.NoError(t, err)
+
+	require.NoError(t, writeFrame(initConn, []byte("short")))
+	initConn.Close() // nolint: errcheck
+
+	require.Error(t, <-respCh)
+}
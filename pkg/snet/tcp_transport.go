@@ -1,3 +1,4 @@
+//go:build !no_ci
 // +build !no_ci
 
 package snet
@@ -21,14 +22,20 @@ var ErrUnknownRemote = errors.New("unknown remote")
 
 // TCPFactory implements Factory over TCP connection.
 type TCPFactory struct {
-	l   *net.TCPListener
-	lpk cipher.PubKey
-	pkt PubKeyTable
+	l       *net.TCPListener
+	lpk     cipher.PubKey
+	lsk     cipher.SecKey
+	pkt     PubKeyTable
+	encrypt bool
 }
 
-// NewTCPFactory constructs a new TCP Factory.
-func NewTCPFactory(lpk cipher.PubKey, pkt PubKeyTable, l *net.TCPListener) *TCPFactory {
-	return &TCPFactory{l, lpk, pkt}
+// NewTCPFactory constructs a new TCP Factory. Connections are authenticated
+// and encrypted with a Noise_XX handshake over the node's static keys
+// unless encrypt is false, in which case frames are exchanged in the
+// clear and the remote is trusted on source IP alone; this is only meant
+// to ease rolling out encryption to a mesh with legacy peers.
+func NewTCPFactory(lpk cipher.PubKey, lsk cipher.SecKey, pkt PubKeyTable, l *net.TCPListener, encrypt bool) *TCPFactory {
+	return &TCPFactory{l, lpk, lsk, pkt, encrypt}
 }
 
 // Accept accepts a remotely-initiated Transport.
@@ -41,18 +48,30 @@ func (f *TCPFactory) Accept(ctx context.Context) (*TCPTransport, error) {
 	raddr := conn.RemoteAddr().(*net.TCPAddr)
 	rpk := f.pkt.RemotePK(raddr.String())
 	if rpk.Null() {
+		conn.Close() // nolint: errcheck
 		return nil, fmt.Errorf("error: %v, raddr: %v, rpk: %v", ErrUnknownRemote, raddr.String(), rpk)
 	}
 
-	// return &TCPTransport{conn, [2]cipher.PubKey{f.Pk, rpk}}, nil
-	return &TCPTransport{conn, f.lpk, rpk}, nil
+	if !f.encrypt {
+		return &TCPTransport{conn, f.lpk, rpk}, nil
+	}
+
+	tr, err := f.encryptConn(conn, false, rpk)
+	if err != nil {
+		conn.Close() // nolint: errcheck
+		return nil, fmt.Errorf("noise handshake with %v failed: %w", raddr, err)
+	}
+	return tr, nil
 }
 
-// Dial initiates a Transport with a remote node.
+// Dial initiates a Transport with a remote node. If remote is not yet
+// known to the configured PubKeyTable, Resolve is given the chance to
+// discover it (e.g. DiscoveryPubKeyTable running a mesh lookup) before
+// Dial gives up with ErrUnknownRemote.
 func (f *TCPFactory) Dial(ctx context.Context, remote cipher.PubKey) (*TCPTransport, error) {
-	raddr := f.pkt.RemoteAddr(remote)
-	if raddr == "" {
-		return nil, ErrUnknownRemote
+	raddr, err := f.pkt.Resolve(ctx, remote)
+	if err != nil {
+		return nil, err
 	}
 
 	tcpAddr, err := net.ResolveTCPAddr("tcp", raddr)
@@ -74,7 +93,38 @@ func (f *TCPFactory) Dial(ctx context.Context, remote cipher.PubKey) (*TCPTransp
 		return nil, err
 	}
 
-	return &TCPTransport{conn, f.lpk, remote}, nil
+	if !f.encrypt {
+		return &TCPTransport{conn, f.lpk, remote}, nil
+	}
+
+	tr, err := f.encryptConn(conn, true, remote)
+	if err != nil {
+		conn.Close() // nolint: errcheck
+		return nil, fmt.Errorf("noise handshake with %v failed: %w", tcpAddr, err)
+	}
+	return tr, nil
+}
+
+// encryptConn runs the Noise_XX handshake over conn, verifying that the
+// remote's presented static key matches expectedPK, and wraps conn in an
+// AEAD-framed net.Conn on success.
+func (f *TCPFactory) encryptConn(conn *net.TCPConn, initiator bool, expectedPK cipher.PubKey) (*TCPTransport, error) {
+	hs, err := newNoiseHandshake(initiator, f.lpk, f.lsk, expectedPK)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := doHandshake(conn, hs)
+	if err != nil {
+		return nil, err
+	}
+
+	ec, err := newAEADConn(conn, res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TCPTransport{ec, f.lpk, res.remoteID}, nil
 }
 
 // Close implements io.Closer
@@ -95,9 +145,11 @@ func (f *TCPFactory) Type() string {
 	return "tcp-transport"
 }
 
-// TCPTransport implements Transport over TCP connection.
+// TCPTransport implements Transport over TCP connection. The wrapped
+// net.Conn is either the raw *net.TCPConn (encryption disabled) or an
+// AEAD-framed conn established by a Noise_XX handshake.
 type TCPTransport struct {
-	*net.TCPConn
+	net.Conn
 	localKey  cipher.PubKey
 	remoteKey cipher.PubKey
 }
@@ -122,6 +174,12 @@ type PubKeyTable interface {
 	RemoteAddr(remotePK cipher.PubKey) string
 	RemotePK(address string) cipher.PubKey
 	Count() int
+
+	// Resolve is like RemoteAddr, but is allowed to block in order to
+	// discover a remotePK it doesn't have cached yet (e.g. by querying a
+	// discovery mesh). It returns ErrUnknownRemote if remotePK cannot be
+	// resolved before ctx is done.
+	Resolve(ctx context.Context, remotePK cipher.PubKey) (string, error)
 }
 
 type memPKTable struct {
@@ -162,6 +220,15 @@ func (t *memPKTable) Count() int {
 	return len(t.entries)
 }
 
+// Resolve looks up remotePK in the in-memory table; it never blocks.
+func (t *memPKTable) Resolve(ctx context.Context, remotePK cipher.PubKey) (string, error) {
+	addr := t.RemoteAddr(remotePK)
+	if addr == "" {
+		return "", ErrUnknownRemote
+	}
+	return addr, nil
+}
+
 type filePKTable struct {
 	dbFile string
 	*memPKTable
@@ -201,4 +268,4 @@ func FilePubKeyTable(dbFile string) (PubKeyTable, error) {
 	}
 
 	return &filePKTable{dbFile, memoryPubKeyTable(entries)}, nil
-}
\ No newline at end of file
+}